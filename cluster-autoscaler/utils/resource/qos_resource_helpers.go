@@ -0,0 +1,376 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resource holds cluster-autoscaler-owned helpers for reasoning
+// about QoS resources (extended/scalar resource classes scheduled outside
+// of the regular CPU/memory requests, e.g. L3 cache or SR-IOV VF classes).
+// QoS resource classes are not part of the upstream Kubernetes scheduling
+// API, so this logic lives here rather than patched into the vendored
+// k8s.io/kubernetes/pkg/api/v1/resource package.
+package resource
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// QOSResourcesTotal stores the total amount of QoS resources. It is a helper
+// type for easier lookups and modifying the data.
+type QOSResourcesTotal map[v1.QOSResourceName]QOSResourceTotal
+
+// QOSResourceTotal stores the total amount of one QoS resource type. That is
+// the set of classes (of that QoS resource type) and the total amount of each
+// class.
+type QOSResourceTotal map[string]int64
+
+// QOSResourcesTotalFromInfo converts a list of QOSResourceInfo into an
+// instance of QOSResourcesTotal.
+func QOSResourcesTotalFromInfo(in []v1.QOSResourceInfo) QOSResourcesTotal {
+	out := make(QOSResourcesTotal, len(in))
+	for _, qr := range in {
+		classes := make(QOSResourceTotal, len(qr.Classes))
+		for _, c := range qr.Classes {
+			classes[c.Name] = c.Capacity
+		}
+		out[qr.Name] = classes
+	}
+	return out
+}
+
+// QOSResourceRequest is a container-level request for one class of a QoS
+// resource, carrying an explicit Quantity. Upstream's v1.QOSResourceRequest
+// has no Quantity field yet, so FromContainerQOSResources is the only way
+// to obtain one from a real pod spec today, and it defaults Quantity to 1.
+// Callers that build requests themselves can set a real Quantity right away,
+// as the PodQOSResourceRequest entries of the Overhead option below do.
+type QOSResourceRequest struct {
+	Name     v1.QOSResourceName
+	Class    string
+	Quantity int64
+}
+
+// PodQOSResourceRequest is the pod-level equivalent of QOSResourceRequest.
+type PodQOSResourceRequest struct {
+	Name     v1.QOSResourceName
+	Class    string
+	Quantity int64
+}
+
+// FromContainerQOSResources converts a container's upstream
+// v1.QOSResourceRequest list into owned QOSResourceRequests, defaulting
+// Quantity to 1 since the upstream type has no quantity of its own yet.
+func FromContainerQOSResources(qrl []v1.QOSResourceRequest) []QOSResourceRequest {
+	if qrl == nil {
+		return nil
+	}
+	out := make([]QOSResourceRequest, 0, len(qrl))
+	for _, qr := range qrl {
+		out = append(out, QOSResourceRequest{Name: qr.Name, Class: qr.Class, Quantity: 1})
+	}
+	return out
+}
+
+// FromPodQOSResources converts a pod's upstream v1.PodQOSResourceRequest
+// list into owned PodQOSResourceRequests, defaulting Quantity to 1 for the
+// same reason as FromContainerQOSResources.
+func FromPodQOSResources(qrl []v1.PodQOSResourceRequest) []PodQOSResourceRequest {
+	if qrl == nil {
+		return nil
+	}
+	out := make([]PodQOSResourceRequest, 0, len(qrl))
+	for _, qr := range qrl {
+		out = append(out, PodQOSResourceRequest{Name: qr.Name, Class: qr.Class, Quantity: 1})
+	}
+	return out
+}
+
+// PodQOSResourceRequestsOptions controls optional behaviors of
+// PodQOSResourceRequests. It is accepted variadically so new options are
+// additive: existing callers that pass none keep the original behavior.
+type PodQOSResourceRequestsOptions struct {
+	// Overhead, if non-empty, is folded into podReqs after the container
+	// sums are computed. This mirrors how kube-scheduler adds
+	// pod.Spec.Overhead on top of container sums in PodRequestsAndLimits,
+	// and exists so the QoS resources consumed by a pod's sandbox itself
+	// (e.g. a kata or gVisor runtime) are not left out of the total. Unlike
+	// requests read off the pod spec, overhead entries are constructed by
+	// the caller and so can already carry a real Quantity.
+	Overhead []PodQOSResourceRequest
+}
+
+// PodQOSResourceRequests calculates the total amount of all QoS resources requested by a Pod.
+func PodQOSResourceRequests(pod *v1.Pod, opts ...PodQOSResourceRequestsOptions) (podReqs, containerReqs QOSResourcesTotal) {
+	podReqs = make(QOSResourcesTotal)
+	containerReqs = make(QOSResourcesTotal)
+
+	podReqs.AddPodQOSResources(FromPodQOSResources(pod.Spec.QOSResources))
+	for _, container := range pod.Spec.Containers {
+		containerReqs.AddContainerQOSResources(FromContainerQOSResources(container.Resources.QOSResources))
+	}
+
+	// Restartable (sidecar) init containers run for the lifetime of the pod,
+	// so they contribute to the running total the same way regular
+	// containers do. Regular init containers still only need to run one at a
+	// time, so take max_resource(sum_pod, any_init_container) for those.
+	for _, container := range pod.Spec.InitContainers {
+		reqs := FromContainerQOSResources(container.Resources.QOSResources)
+		if isRestartableInitContainer(&container) {
+			containerReqs.AddContainerQOSResources(reqs)
+		} else {
+			containerReqs.SetMaxContainerQOSResources(reqs)
+		}
+	}
+
+	for _, opt := range opts {
+		podReqs.AddPodQOSResources(opt.Overhead)
+	}
+
+	return podReqs, containerReqs
+}
+
+// isRestartableInitContainer returns true if the init container has
+// RestartPolicy set to Always, making it a sidecar that runs for the pod's
+// entire lifetime (KEP-753).
+func isRestartableInitContainer(container *v1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == v1.ContainerRestartPolicyAlways
+}
+
+// AddPodQOSResources adds a list of pod-level QoS resource requests into the total.
+func (r *QOSResourcesTotal) AddPodQOSResources(qrl []PodQOSResourceRequest) {
+	if r == nil {
+		return
+	}
+
+	for _, qr := range qrl {
+		r.add(qr.Name, qr.Class, quantityOrDefault(qr.Quantity))
+	}
+}
+
+// AddContainerQOSResources adds a list of container-level QoS resource requests into the total.
+func (r *QOSResourcesTotal) AddContainerQOSResources(qrl []QOSResourceRequest) {
+	if r == nil {
+		return
+	}
+
+	for _, qr := range qrl {
+		r.add(qr.Name, qr.Class, quantityOrDefault(qr.Quantity))
+	}
+}
+
+// SetMaxContainerQOSResources sets each value to the greater of its current
+// value and each request's quantity.
+func (r *QOSResourcesTotal) SetMaxContainerQOSResources(qrl []QOSResourceRequest) {
+	if r == nil {
+		return
+	}
+
+	for _, qr := range qrl {
+		amount := quantityOrDefault(qr.Quantity)
+		if _, have := r.GetAmount(qr.Name, qr.Class); amount > have {
+			r.add(qr.Name, qr.Class, amount-have)
+		}
+	}
+}
+
+// quantityOrDefault returns the requested quantity, defaulting to 1 when
+// unset (e.g. a request converted from an upstream v1 type that has no
+// Quantity field yet), so pod specs written before Quantity existed keep
+// their original single-unit meaning.
+func quantityOrDefault(quantity int64) int64 {
+	if quantity <= 0 {
+		return 1
+	}
+	return quantity
+}
+
+// GetAmount gets the total amount of one class of a QoS resource. It returns a
+// boolean and an integer. The boolean tells whether the resource type and the
+// class exist. The integer is the amount and is only valid if the boolean is
+// true.
+func (r *QOSResourcesTotal) GetAmount(name v1.QOSResourceName, class string) (bool, int64) {
+	if r == nil || *r == nil {
+		return false, 0
+	}
+	if _, ok := (*r)[name]; !ok {
+		// QoS resource does not exist
+		return false, 0
+	}
+	if amount, ok := (*r)[name][class]; ok {
+		return true, amount
+	}
+	// Class does not exist
+	return false, 0
+}
+
+// Sum adds together two QOSResourcesTotal instances.
+func (r *QOSResourcesTotal) Sum(r2 *QOSResourcesTotal, add bool) {
+	if r == nil || r2 == nil {
+		return
+	}
+	for resName, resTotal := range *r2 {
+		for clsName, clsAmount := range resTotal {
+			if add {
+				r.add(resName, clsName, clsAmount)
+			} else {
+				r.add(resName, clsName, -1*clsAmount)
+			}
+		}
+	}
+}
+
+// QOSResourceShortage describes how much of one class of a QoS resource was
+// missing when checking Fits.
+type QOSResourceShortage struct {
+	// Name is the QoS resource type that is short.
+	Name v1.QOSResourceName
+	// Class is the class of Name that is short.
+	Class string
+	// Shortage is the amount by which the demand exceeds the capacity.
+	Shortage int64
+}
+
+// Fits returns whether r's demand can be satisfied by capacity, and, if not,
+// the list of (name, class) shortages. A resource name or class that is
+// present in r but absent from capacity means that capacity cannot supply
+// that class at all, and is reported as a shortage of the full demand.
+func (r *QOSResourcesTotal) Fits(capacity *QOSResourcesTotal) (bool, []QOSResourceShortage) {
+	if r == nil || *r == nil {
+		return true, nil
+	}
+
+	var shortages []QOSResourceShortage
+	for name, classes := range *r {
+		for class, demand := range classes {
+			ok, have := capacity.GetAmount(name, class)
+			if !ok {
+				shortages = append(shortages, QOSResourceShortage{Name: name, Class: class, Shortage: demand})
+				continue
+			}
+			if demand > have {
+				shortages = append(shortages, QOSResourceShortage{Name: name, Class: class, Shortage: demand - have})
+			}
+		}
+	}
+	return len(shortages) == 0, shortages
+}
+
+// Subtract returns the capacity remaining after r's demand is deducted from
+// capacity. Classes that would go negative are floored at zero.
+func (r *QOSResourcesTotal) Subtract(capacity *QOSResourcesTotal) *QOSResourcesTotal {
+	out := capacity.Clone()
+	if r == nil || *r == nil || out == nil {
+		return out
+	}
+
+	for name, classes := range *r {
+		for class, demand := range classes {
+			ok, have := out.GetAmount(name, class)
+			if !ok {
+				continue
+			}
+			remaining := have - demand
+			if remaining < 0 {
+				remaining = 0
+			}
+			(*out)[name][class] = remaining
+		}
+	}
+	return out
+}
+
+// Clone creates a (deep) copy of the QOSResourcesTotal instance.
+func (r *QOSResourcesTotal) Clone() *QOSResourcesTotal {
+	if r == nil {
+		return nil
+	}
+	out := make(QOSResourcesTotal, len(*r))
+	for k, v := range *r {
+		classes := make(QOSResourceTotal, len(v))
+		for c, amount := range v {
+			classes[c] = amount
+		}
+		out[k] = classes
+	}
+	return &out
+}
+
+// add increases total of one resource/class by the given amount.
+func (r *QOSResourcesTotal) add(name v1.QOSResourceName, class string, amount int64) {
+	if r == nil {
+		return
+	}
+	if *r == nil {
+		*r = make(QOSResourcesTotal)
+	}
+	if (*r)[name] == nil {
+		(*r)[name] = make(QOSResourceTotal)
+	}
+	(*r)[name][class] = (*r)[name][class] + amount
+}
+
+// NodeQOSResourceAllocation holds the per-QoS-resource capacity, requested
+// and free amounts of a node, analogous to how getNodeAllocatedResources
+// computes CPU/memory allocation for the dashboard.
+type NodeQOSResourceAllocation struct {
+	Capacity  QOSResourcesTotal
+	Requested QOSResourcesTotal
+	Free      QOSResourcesTotal
+}
+
+// NewNodeQOSResourceAllocation computes the QoS resource allocation of node
+// from the pods bound to it. Pods in a terminal phase (Succeeded or Failed)
+// are skipped, the same way the kubelet excludes them from allocation
+// accounting.
+func NewNodeQOSResourceAllocation(node *v1.Node, pods []*v1.Pod) *NodeQOSResourceAllocation {
+	capacity := QOSResourcesTotalFromInfo(node.Status.QOSResources)
+	requested := make(QOSResourcesTotal, len(capacity))
+
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		podReqs, containerReqs := PodQOSResourceRequests(pod)
+		requested.Sum(&podReqs, true)
+		requested.Sum(&containerReqs, true)
+	}
+
+	free := requested.Subtract(&capacity)
+
+	return &NodeQOSResourceAllocation{
+		Capacity:  capacity,
+		Requested: requested,
+		Free:      *free,
+	}
+}
+
+// Utilization returns, per QoS resource name and class, the fraction of
+// capacity that is currently requested. A class with zero capacity reports
+// zero utilization rather than dividing by zero.
+func (a *NodeQOSResourceAllocation) Utilization() map[v1.QOSResourceName]map[string]float64 {
+	out := make(map[v1.QOSResourceName]map[string]float64, len(a.Capacity))
+	for name, classes := range a.Capacity {
+		classUtil := make(map[string]float64, len(classes))
+		for class, capAmount := range classes {
+			if capAmount == 0 {
+				classUtil[class] = 0
+				continue
+			}
+			_, have := a.Requested.GetAmount(name, class)
+			classUtil[class] = float64(have) / float64(capAmount)
+		}
+		out[name] = classUtil
+	}
+	return out
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resource
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func restartPolicy(p v1.ContainerRestartPolicy) *v1.ContainerRestartPolicy {
+	return &p
+}
+
+func TestPodQOSResourceRequests_InitContainers(t *testing.T) {
+	for name, tc := range map[string]struct {
+		initContainers []v1.Container
+		wantContainer  QOSResourceTotal
+	}{
+		"non-restartable init container takes max against containers": {
+			initContainers: []v1.Container{
+				{Resources: v1.ResourceRequirements{QOSResources: []v1.QOSResourceRequest{{Name: "example.com/cache", Class: "gold"}}}},
+			},
+			wantContainer: QOSResourceTotal{"gold": 1},
+		},
+		"restartable (sidecar) init container adds on top of containers": {
+			initContainers: []v1.Container{
+				{
+					RestartPolicy: restartPolicy(v1.ContainerRestartPolicyAlways),
+					Resources:     v1.ResourceRequirements{QOSResources: []v1.QOSResourceRequest{{Name: "example.com/cache", Class: "gold"}}},
+				},
+			},
+			wantContainer: QOSResourceTotal{"gold": 2},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pod := &v1.Pod{Spec: v1.PodSpec{
+				Containers:     []v1.Container{{Resources: v1.ResourceRequirements{QOSResources: []v1.QOSResourceRequest{{Name: "example.com/cache", Class: "gold"}}}}},
+				InitContainers: tc.initContainers,
+			}}
+
+			_, containerReqs := PodQOSResourceRequests(pod)
+
+			assert.Equal(t, tc.wantContainer, containerReqs["example.com/cache"])
+		})
+	}
+}
+
+func TestPodQOSResourceRequests_Overhead(t *testing.T) {
+	pod := &v1.Pod{Spec: v1.PodSpec{
+		QOSResources: []v1.PodQOSResourceRequest{{Name: "example.com/cache", Class: "gold"}},
+	}}
+
+	podReqs, _ := PodQOSResourceRequests(pod)
+	assert.Equal(t, int64(1), podReqs["example.com/cache"]["gold"], "no options passed should leave existing callers unaffected")
+
+	podReqs, _ = PodQOSResourceRequests(pod, PodQOSResourceRequestsOptions{
+		Overhead: []PodQOSResourceRequest{{Name: "example.com/cache", Class: "gold", Quantity: 2}},
+	})
+	assert.Equal(t, int64(3), podReqs["example.com/cache"]["gold"], "overhead should be folded on top of the pod-level sum")
+}
+
+func TestQOSResourcesTotal_Fits(t *testing.T) {
+	demand := QOSResourcesTotal{"example.com/cache": {"gold": 3}}
+
+	for name, tc := range map[string]struct {
+		capacity   QOSResourcesTotal
+		wantFits   bool
+		wantShorts []QOSResourceShortage
+	}{
+		"capacity covers demand": {
+			capacity: QOSResourcesTotal{"example.com/cache": {"gold": 5}},
+			wantFits: true,
+		},
+		"class present but short": {
+			capacity:   QOSResourcesTotal{"example.com/cache": {"gold": 1}},
+			wantFits:   false,
+			wantShorts: []QOSResourceShortage{{Name: "example.com/cache", Class: "gold", Shortage: 2}},
+		},
+		"resource name unknown to capacity is a full shortage": {
+			capacity:   QOSResourcesTotal{"example.com/other": {"gold": 5}},
+			wantFits:   false,
+			wantShorts: []QOSResourceShortage{{Name: "example.com/cache", Class: "gold", Shortage: 3}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fits, shortages := demand.Fits(&tc.capacity)
+			assert.Equal(t, tc.wantFits, fits)
+			assert.Equal(t, tc.wantShorts, shortages)
+		})
+	}
+}
+
+func TestQOSResourcesTotal_Subtract(t *testing.T) {
+	capacity := QOSResourcesTotal{"example.com/cache": {"gold": 2}}
+	demand := QOSResourcesTotal{"example.com/cache": {"gold": 5}}
+
+	remaining := demand.Subtract(&capacity)
+
+	assert.Equal(t, int64(0), (*remaining)["example.com/cache"]["gold"], "remaining capacity should floor at zero rather than go negative")
+}
+
+func TestQuantityOrDefault(t *testing.T) {
+	assert.Equal(t, int64(1), quantityOrDefault(0), "unset quantity should default to 1")
+	assert.Equal(t, int64(1), quantityOrDefault(-1), "negative quantity should default to 1")
+	assert.Equal(t, int64(4), quantityOrDefault(4), "a positive quantity should be kept as-is")
+}
+
+func TestQOSResourcesTotal_AddContainerQOSResources_Quantity(t *testing.T) {
+	var total QOSResourcesTotal
+	total.AddContainerQOSResources([]QOSResourceRequest{
+		{Name: "example.com/vf", Class: "a", Quantity: 2},
+		{Name: "example.com/vf", Class: "a", Quantity: 3},
+	})
+
+	assert.Equal(t, int64(5), total["example.com/vf"]["a"])
+}
+
+func TestQOSResourcesTotal_SetMaxContainerQOSResources_Quantity(t *testing.T) {
+	var total QOSResourcesTotal
+	total.SetMaxContainerQOSResources([]QOSResourceRequest{{Name: "example.com/vf", Class: "a", Quantity: 2}})
+	total.SetMaxContainerQOSResources([]QOSResourceRequest{{Name: "example.com/vf", Class: "a", Quantity: 5}})
+	total.SetMaxContainerQOSResources([]QOSResourceRequest{{Name: "example.com/vf", Class: "a", Quantity: 1}})
+
+	assert.Equal(t, int64(5), total["example.com/vf"]["a"], "max should be kept across calls regardless of order")
+}
+
+func TestNewNodeQOSResourceAllocation(t *testing.T) {
+	node := &v1.Node{Status: v1.NodeStatus{QOSResources: []v1.QOSResourceInfo{
+		{Name: "example.com/cache", Classes: []v1.QOSResourceClassInfo{{Name: "gold", Capacity: 4}}},
+	}}}
+
+	running := &v1.Pod{
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+		Spec:   v1.PodSpec{QOSResources: []v1.PodQOSResourceRequest{{Name: "example.com/cache", Class: "gold"}}},
+	}
+	succeeded := &v1.Pod{
+		Status: v1.PodStatus{Phase: v1.PodSucceeded},
+		Spec:   v1.PodSpec{QOSResources: []v1.PodQOSResourceRequest{{Name: "example.com/cache", Class: "gold"}}},
+	}
+
+	alloc := NewNodeQOSResourceAllocation(node, []*v1.Pod{running, succeeded})
+
+	assert.Equal(t, int64(4), alloc.Capacity["example.com/cache"]["gold"])
+	assert.Equal(t, int64(1), alloc.Requested["example.com/cache"]["gold"], "terminal pods should be skipped")
+	assert.Equal(t, int64(3), alloc.Free["example.com/cache"]["gold"])
+	assert.Equal(t, 0.25, alloc.Utilization()["example.com/cache"]["gold"])
+}
+
+func TestNodeQOSResourceAllocation_UtilizationZeroCapacity(t *testing.T) {
+	alloc := &NodeQOSResourceAllocation{
+		Capacity:  QOSResourcesTotal{"example.com/cache": {"gold": 0}},
+		Requested: QOSResourcesTotal{},
+	}
+
+	assert.Equal(t, 0.0, alloc.Utilization()["example.com/cache"]["gold"], "zero capacity should report zero utilization instead of dividing by zero")
+}